@@ -0,0 +1,119 @@
+package app
+
+import (
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// replacePollInterval 是 ReplaceIfStillRunning 等待上一次调度真正退出 TaskExecutingTable
+// 时的轮询间隔。退出需要经过「感知 CancelFunc -> ExecuteTask 返回 -> DeleteExecutingTask ->
+// 释放 etcd 锁」这几步，不是 cancel 调用完就立刻完成的，所以不能直接往下执行
+const replacePollInterval = 10 * time.Millisecond
+
+// ConcurrencyPolicy 决定同一个 schedulerKey 的上一次调度还没结束时，新一次触发应该怎么办。
+// 取值随任务保存在 common.Task.ConcurrencyPolicy 上，通过 TASK_EVENT_SAVE 下发，
+// concurrencyTable 只是调度器侧的运行时缓存（避免每次 TryStartTask 都要反查 Task）
+type ConcurrencyPolicy int
+
+const (
+	// ConcurrencySkip 跳过本次调度，只打点不产生错误结果（默认策略，兼容过去 Skip 的行为）
+	ConcurrencySkip ConcurrencyPolicy = iota
+	// ConcurrencyAllow 允许与上一次调度并行执行，不做任何去重
+	ConcurrencyAllow
+	// ConcurrencyQueue 把本次调度放进一个有界 FIFO，待上一次调度结束后立刻取出执行
+	ConcurrencyQueue
+	// ConcurrencyReplace cancel 掉上一次还在执行的调度，立刻开始本次调度
+	ConcurrencyReplace
+	// ConcurrencyDelay 阻塞等待上一次调度结束后再执行本次调度，与 ConcurrencyQueue 的区别是
+	// 不经过有界 FIFO，调度协程会原地等待（见 DelayIfStillRunning），适合低频、允许阻塞的任务
+	ConcurrencyDelay
+)
+
+// defaultQueueSize 是 ConcurrencyQueue 策略下单个任务的默认队列深度
+const defaultQueueSize = 16
+
+// SetConcurrencyPolicy 设置某个 schedulerKey 的并发策略，可以在运行时通过 TASK_EVENT_SAVE 反复调用来修改
+func (ts *TaskScheduler) SetConcurrencyPolicy(key string, policy ConcurrencyPolicy) {
+	ts.concurrencyTable.Store(key, policy)
+}
+
+// GetConcurrencyPolicy 返回 key 对应的并发策略，未设置过时返回 ConcurrencySkip
+func (ts *TaskScheduler) GetConcurrencyPolicy(key string) ConcurrencyPolicy {
+	if value, ok := ts.concurrencyTable.Load(key); ok {
+		return value.(ConcurrencyPolicy)
+	}
+	return ConcurrencySkip
+}
+
+// pendingQueue 返回（必要时创建）key 对应的有界等待队列
+func (ts *TaskScheduler) pendingQueue(key string) chan *common.TaskSchedulePlan {
+	if value, ok := ts.queueTable.Load(key); ok {
+		return value.(chan *common.TaskSchedulePlan)
+	}
+	queue := make(chan *common.TaskSchedulePlan, defaultQueueSize)
+	actual, _ := ts.queueTable.LoadOrStore(key, queue)
+	return actual.(chan *common.TaskSchedulePlan)
+}
+
+// enqueuePending 把 plan 放进 key 对应的等待队列，队列满时丢弃队首最旧的一条（drop-oldest）
+func (ts *TaskScheduler) enqueuePending(key string, plan *common.TaskSchedulePlan) {
+	queue := ts.pendingQueue(key)
+	for {
+		select {
+		case queue <- plan:
+			return
+		default:
+			select {
+			case <-queue:
+			default:
+			}
+		}
+	}
+}
+
+// drainPending 取出 key 对应等待队列中最早的一条并通过 rerun 重新调度，队列为空时什么也不做
+func (ts *TaskScheduler) drainPending(key string, rerun func(*common.TaskSchedulePlan)) {
+	queue := ts.pendingQueue(key)
+	select {
+	case plan := <-queue:
+		rerun(plan)
+	default:
+	}
+}
+
+// ReplaceIfStillRunning 如果上一次调度还未结束，cancel 掉它后等待它真正退出
+// TaskExecutingTable（意味着已经释放了 etcd 锁）再执行本次调度。不能在 cancel 之后立刻
+// j()：job 内部会用同一个 key 重新 a.etcd.Lock().TryLock()，而被 cancel 的上一个实例这时
+// 还没跑到它的 defer lk.Unlock()，TryLock 必然失败，结果是新旧两次调度都被丢弃，比 Skip 还差
+func ReplaceIfStillRunning(ts *TaskScheduler, key string) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			if info, executing := ts.CheckTaskExecuting(key); executing {
+				info.CancelFunc()
+				for {
+					if _, stillExecuting := ts.CheckTaskExecuting(key); !stillExecuting {
+						break
+					}
+					time.Sleep(replacePollInterval)
+				}
+			}
+			j()
+		}
+	}
+}
+
+// QueueIfStillRunning 如果上一次调度还未结束，把本次调度放进有界 FIFO 排队，
+// 上一次调度结束后 drainPending 会立刻取出并重新调度
+func QueueIfStillRunning(ts *TaskScheduler, key string, plan *common.TaskSchedulePlan, rerun func(*common.TaskSchedulePlan)) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			if _, executing := ts.CheckTaskExecuting(key); executing {
+				ts.enqueuePending(key, plan)
+				return
+			}
+			j()
+			ts.drainPending(key, rerun)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"container/heap"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// planHeapItem 是优先队列中的一个节点，index 由 container/heap 维护，配合 planIndex
+// 可以在 O(log N) 内定位并修复/移除任意一个任务，而不必遍历整个堆
+type planHeapItem struct {
+	key   string
+	plan  *common.TaskSchedulePlan
+	index int
+}
+
+// planHeap 按 plan.NextTime 升序排列，堆顶永远是最近一次需要触发调度的任务
+type planHeap []*planHeapItem
+
+func (h planHeap) Len() int { return len(h) }
+
+func (h planHeap) Less(i, j int) bool {
+	return h[i].plan.NextTime.Before(h[j].plan.NextTime)
+}
+
+func (h planHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *planHeap) Push(x interface{}) {
+	item := x.(*planHeapItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *planHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+var _ heap.Interface = (*planHeap)(nil)
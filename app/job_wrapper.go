@@ -0,0 +1,101 @@
+package app
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Job 代表一次任务调度真正要执行的动作，JobWrapper 在不改变调用方式的前提下为其叠加行为
+type Job func()
+
+// JobWrapper 包裹一个 Job 并返回附加了额外行为的新 Job，用于组合跳过重入/延迟/恢复等策略
+type JobWrapper func(Job) Job
+
+// Chain 按 wrappers 给定的顺序把它们层层包裹在 j 外面，wrappers[0] 最先被调用
+func Chain(j Job, wrappers ...JobWrapper) Job {
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		j = wrappers[i](j)
+	}
+	return j
+}
+
+// Recover 捕获 Job 执行过程中的 panic，避免单次任务异常导致调度协程整体崩溃
+func Recover(logger logrus.FieldLogger) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.WithFields(logrus.Fields{
+						"panic": r,
+						"stack": string(debug.Stack()),
+					}).Error("task execution panic")
+				}
+			}()
+			j()
+		}
+	}
+}
+
+// SkipIfStillRunning 如果同一 schedulerKey 上一次调度尚未结束，则跳过本次调度
+// onSkip 可用于上报跳过事件（打点/返回结果），为空时静默跳过
+func SkipIfStillRunning(ts *TaskScheduler, key string, onSkip func()) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			if _, executing := ts.CheckTaskExecuting(key); executing {
+				if onSkip != nil {
+					onSkip()
+				}
+				return
+			}
+			j()
+		}
+	}
+}
+
+// DelayIfStillRunning 如果同一 schedulerKey 上一次调度尚未结束，则阻塞等待其结束后再执行本次调度
+// 超过 1s 的等待会记录一条 warning，方便定位任务堆积
+func DelayIfStillRunning(ts *TaskScheduler, key string, logger logrus.FieldLogger) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			start := time.Now()
+			for {
+				if _, executing := ts.CheckTaskExecuting(key); !executing {
+					break
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				logger.WithFields(logrus.Fields{
+					"key":   key,
+					"delay": elapsed,
+				}).Warn("task execution delayed by still-running previous instance")
+			}
+			j()
+		}
+	}
+}
+
+// Timeout 为 Job 设置最长执行时间，超时后调用 onTimeout（通常用于取消任务的 context），
+// Job 本身需要感知取消信号才能真正提前结束，Timeout 自身只负责计时与上报
+func Timeout(d time.Duration, onTimeout func()) JobWrapper {
+	return func(j Job) Job {
+		return func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				j()
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(d):
+				if onTimeout != nil {
+					onTimeout()
+				}
+				<-done
+			}
+		}
+	}
+}
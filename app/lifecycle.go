@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// defaultDrainDeadline 是 Stop 等待在执行中任务优雅退出的默认时长，超过后会强制 cancel
+const defaultDrainDeadline = 30 * time.Second
+
+// Start 启动调度主循环，ctx 被取消时 Loop 会停止接收新的事件/调度周期（但不会等待收尾，
+// 收尾请调用 Stop）
+func (a *client) Start(ctx context.Context) error {
+	atomic.StoreInt32(&a.scheduler.closed, 0)
+
+	// 先把 etcd 里已经持久化但还没执行的一次性任务回放进 OnceTable，再开始消费事件，
+	// 否则 worker 重启后这些任务会一直躺在 etcd 里没有任何节点知道要执行它们
+	if err := a.WatchOnceTasks(); err != nil {
+		return fmt.Errorf("watch once tasks error: %w", err)
+	}
+
+	go a.Loop(ctx)
+	return nil
+}
+
+// Stop 按顺序执行优雅关闭：
+//  1. 停止接收新的 TaskEventChan 事件
+//  2. 等待 drainDeadline（取自 ctx 的截止时间，没有则用 defaultDrainDeadline）后
+//     cancel 所有仍在执行中的任务
+//  3. 等待 TryStartTask/TryStartOnceTask 启动的 goroutine 全部退出
+//  4. 把 TaskExecuteResultChan 里剩余的结果 flush 给 ResultReport
+//  5. 释放仍然持有的 etcd 分布式锁
+func (a *client) Stop(ctx context.Context) error {
+	ts := a.scheduler
+	atomic.StoreInt32(&ts.closed, 1)
+
+	deadline := defaultDrainDeadline
+	if d, ok := ctx.Deadline(); ok {
+		deadline = time.Until(d)
+		if deadline < 0 {
+			deadline = 0
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		ts.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(deadline):
+		// 超过 drain deadline 仍有任务在执行中，强制 cancel 后继续等待 goroutine 退出
+		ts.TaskExecutingTable.Range(func(_, value interface{}) bool {
+			value.(*common.TaskExecutingInfo).CancelFunc()
+			return true
+		})
+		<-drained
+	}
+
+	// flush 剩余的执行结果，避免 Stop 之后这些结果无人处理
+	for {
+		select {
+		case result := <-ts.TaskExecuteResultChan:
+			a.handleTaskResult(result)
+		default:
+			a.etcd.ReleaseAll()
+			return nil
+		}
+	}
+}
+
+// closed 用独立的 int32 字段而不是 bool，配合 atomic 在高频的 PushEvent 路径上做无锁判断
+func (ts *TaskScheduler) isClosed() bool {
+	return atomic.LoadInt32(&ts.closed) == 1
+}
+
+// RunUntilSignal 启动调度器并阻塞，直到收到 SIGINT/SIGTERM 后按 drainDeadline 走完优雅关闭
+// 流程再返回。客户端入口（cmd/worker）应当用这个方法代替直接调用 Loop
+func (a *client) RunUntilSignal(drainDeadline time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.Start(ctx); err != nil {
+		cancel()
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	cancel()
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), drainDeadline)
+	defer stopCancel()
+	return a.Stop(stopCtx)
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// TASK_EVENT_ONCE 标识一次性/延迟任务事件，与 common.TASK_EVENT_* 系列并列使用
+// （该常量最终应当迁移进 common 包统一维护，这里先在 app 内声明以解耦本次改动）
+const TASK_EVENT_ONCE = 100
+
+// onceJobSaveDir 是一次性任务在 etcd 中的存储前缀，与常规任务的 /cron/jobs/ 平行存在
+const onceJobSaveDir = "/cron/oncejobs/"
+
+// onceJobDefaultRetention 是一次性任务执行失败后在 etcd 中默认的保留时长，超过该时长后台会清理；
+// 执行成功的记录会立即删除，不受它影响
+const onceJobDefaultRetention = 10 * time.Minute
+
+// OnceTaskEntry 描述一个一次性任务，RunAt 是其应当被触发的绝对时间
+type OnceTaskEntry struct {
+	Task      *common.Task
+	RunAt     time.Time
+	Retention time.Duration // 执行失败后在 etcd 中保留的时长，0 使用 onceJobDefaultRetention
+}
+
+// BuildOnceTaskExecuteInfo 构建一次性任务的执行状态信息，复用 common.BuildTaskExecuteInfo
+// 的逻辑，只是用 RunAt 代替常规任务由 Expr 计算出的 NextTime
+func BuildOnceTaskExecuteInfo(entry *OnceTaskEntry) *common.TaskExecutingInfo {
+	return common.BuildTaskExecuteInfo(&common.TaskSchedulePlan{
+		Task:     entry.Task,
+		NextTime: entry.RunAt,
+	})
+}
+
+// SetOnceTask 登记一个待执行的一次性任务
+func (ts *TaskScheduler) SetOnceTask(key string, entry *OnceTaskEntry) {
+	ts.OnceTable.Store(key, entry)
+}
+
+// DeleteOnceTask 从内存表中移除一次性任务，执行完成或被取消时调用
+func (ts *TaskScheduler) DeleteOnceTask(key string) {
+	ts.OnceTable.Delete(key)
+}
+
+// OnceRange 遍历所有待执行的一次性任务
+func (ts *TaskScheduler) OnceRange(f func(key string, entry *OnceTaskEntry) bool) {
+	ts.OnceTable.Range(func(key, value interface{}) bool {
+		return f(key.(string), value.(*OnceTaskEntry))
+	})
+}
+
+// OnceCount 返回当前等待执行的一次性任务数量
+func (ts *TaskScheduler) OnceCount() int {
+	var count int
+	ts.OnceTable.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// SubmitOnceTask 提交一个一次性任务：写入 etcd 持久化即可，TASK_EVENT_ONCE 事件由
+// WatchOnceTasks 注册的 watcher 在 SaveOnceTask 触发 onPut 时统一推送，不在这里重复
+// PushEvent —— 否则本节点会同时收到这里的直推和 watch 回调两份一样的事件，提交和
+// etcd-watch 重放就变成了两条平行的入口，而不是请求要求的同一条路径
+// HTTP/gRPC 层的提交接口最终应当调用这个方法，具体的路由绑定在 http/rpc 包内完成
+func (a *client) SubmitOnceTask(task *common.Task, runAt time.Time) error {
+	if err := a.etcd.SaveOnceTask(onceJobSaveDir+task.SchedulerKey(), task, runAt); err != nil {
+		return fmt.Errorf("save once task to etcd error: %w", err)
+	}
+	return nil
+}
+
+// handleOnceTaskEvent 处理 TASK_EVENT_ONCE 事件，登记任务并交给 TrySchedule 统一调度
+func (a *client) handleOnceTaskEvent(event *common.TaskEvent) {
+	key := event.Task.SchedulerKey()
+	a.scheduler.SetOnceTask(key, &OnceTaskEntry{
+		Task:  event.Task,
+		RunAt: event.RunAt,
+	})
+}
+
+// WatchOnceTasks 订阅 etcd 上 onceJobSaveDir 前缀的一次性任务：启动时先把已经持久化但还没
+// 执行的记录全部推回 TaskEventChan（worker 重启后不会丢失这些任务），之后每当有新的记录被
+// 其它节点写入/删除也会同步更新到 OnceTable，从而满足"像常规任务一样被 watch"的要求。
+// 应当在 Start 里、Loop 开始消费事件之前调用一次
+func (a *client) WatchOnceTasks() error {
+	return a.etcd.WatchOnceTasks(onceJobSaveDir,
+		func(task *common.Task, runAt time.Time) {
+			a.scheduler.PushEvent(&common.TaskEvent{
+				EventType: TASK_EVENT_ONCE,
+				Task:      task,
+				RunAt:     runAt,
+			})
+		},
+		func(etcdKey string) {
+			// OnceTable 用裸的 SchedulerKey 做索引，回调里收到的是带 onceJobSaveDir 前缀的 etcd key
+			a.scheduler.DeleteOnceTask(strings.TrimPrefix(etcdKey, onceJobSaveDir))
+		},
+	)
+}
+
+// TryStartOnceTask 与 TryStartTask 类似，但一次性任务无论成功与否都会在触发后从调度表中移除，
+// 并且执行成功后会清理 etcd 中的持久化记录，保证集群内只执行一次
+func (a *client) TryStartOnceTask(entry *OnceTaskEntry) {
+	key := entry.Task.SchedulerKey()
+	a.scheduler.DeleteOnceTask(key)
+
+	entry.Task.ClientIP = a.localip
+
+	job := func() {
+		taskExecuteInfo := BuildOnceTaskExecuteInfo(entry)
+		// 与 TryStartTask 一样不能让 CancelFunc 留空，Kill 和 Stop 会直接调用它
+		_, cancel := context.WithCancel(context.Background())
+		taskExecuteInfo.CancelFunc = cancel
+		defer cancel()
+
+		lk := a.etcd.Lock(entry.Task)
+		// 避免分布式集群上锁偏斜 (每台机器的时钟可能不是特别的准确 导致某一台机器总能抢到锁)
+		// jitter 窗口与常规任务共用 a.scheduler.lockJitter，保持两条路径的抖动行为一致
+		time.Sleep(time.Duration(rand.Int63n(int64(a.scheduler.lockJitter) + 1)))
+		if err := lk.TryLock(); err != nil {
+			a.logger.Warnf("once task: %s, id: %s, lock error, %v", entry.Task.Name,
+				entry.Task.TaskID, err)
+			return
+		}
+		defer lk.Unlock()
+
+		a.scheduler.SetExecutingTask(key, taskExecuteInfo)
+		result := a.ExecuteTask(taskExecuteInfo)
+		a.scheduler.DeleteExecutingTask(key)
+		a.scheduler.PushTaskResult(result)
+
+		retention := entry.Retention
+		if retention == 0 {
+			retention = onceJobDefaultRetention
+		}
+		if result.Err == "" {
+			// 成功执行后立即清理，避免同一条记录被重复调度
+			if err := a.etcd.DeleteOnceTask(onceJobSaveDir + key); err != nil {
+				a.logger.Errorf("once task: %s, id: %s, delete from etcd error: %v",
+					entry.Task.Name, entry.Task.TaskID, err)
+			}
+		} else {
+			// 失败的记录保留一段时间供排查，超过 retention 后由后台清理任务回收。
+			// 计入 ts.wg 以便 Stop 优雅关闭时能等到这个清理动作完成，而不是留下一条
+			// 没人等待、关闭期间可能被截断的游离 goroutine
+			a.scheduler.wg.Add(1)
+			time.AfterFunc(retention, func() {
+				defer a.scheduler.wg.Done()
+				if err := a.etcd.DeleteOnceTask(onceJobSaveDir + key); err != nil {
+					a.logger.Errorf("once task: %s, id: %s, delayed delete from etcd error: %v",
+						entry.Task.Name, entry.Task.TaskID, err)
+				}
+			})
+		}
+	}
+
+	// 与 TryStartTask 的 chunk0-5 修复一致：Recover 必须始终生效，不能在用户配置了自定义
+	// WrapperChain 时被悄悄丢弃，否则一次性任务 panic 会直接打垮调度协程
+	wrappers := make([]JobWrapper, 0, len(a.scheduler.WrapperChain)+1)
+	wrappers = append(wrappers, a.scheduler.WrapperChain...)
+	wrappers = append(wrappers, Recover(a.logger))
+
+	a.scheduler.wg.Add(1)
+	go func() {
+		defer a.scheduler.wg.Done()
+		Chain(job, wrappers...)()
+	}()
+}
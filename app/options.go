@@ -0,0 +1,115 @@
+package app
+
+import (
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Clock 抽象时间源，测试里可以注入确定性的时钟，生产环境使用 realClock
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// MetricsReporter 接收调度器内部产生的打点（跳过次数、执行延迟等），默认不上报
+type MetricsReporter interface {
+	Incr(metric string, tags map[string]string)
+	Observe(metric string, value float64, tags map[string]string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Incr(string, map[string]string)             {}
+func (noopMetrics) Observe(string, float64, map[string]string) {}
+
+// SchedulerOption 是 NewScheduler 的函数式选项
+type SchedulerOption func(*TaskScheduler)
+
+// WithEventBuffer 设置 TaskEventChan 的缓冲区大小，默认 3000
+func WithEventBuffer(n int) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.eventBuffer = n }
+}
+
+// WithResultBuffer 设置 TaskExecuteResultChan 的缓冲区大小，默认 3000
+func WithResultBuffer(n int) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.resultBuffer = n }
+}
+
+// WithLockJitter 设置抢占分布式锁前的随机抖动上限，用于避免多机时钟偏差导致锁总被同一台抢到，默认 1s
+func WithLockJitter(d time.Duration) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.lockJitter = d }
+}
+
+// WithRetryPolicy 设置任务运行状态上报失败后的重试策略，backoff 为 nil 时不等待直接重试
+func WithRetryPolicy(attempts int, backoff func(attempt int) time.Duration) SchedulerOption {
+	return func(ts *TaskScheduler) {
+		ts.retryAttempts = attempts
+		ts.retryBackoff = backoff
+	}
+}
+
+// WithLogger 注入自定义 logger，默认使用 logrus 的全局 logger
+func WithLogger(l logrus.FieldLogger) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.logger = l }
+}
+
+// WithMetrics 注入自定义指标上报器，默认不上报
+func WithMetrics(reporter MetricsReporter) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.metrics = reporter }
+}
+
+// WithClock 注入自定义时间源，便于单测里做确定性调度
+func WithClock(clock Clock) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.clock = clock }
+}
+
+// WithJobWrappers 设置默认的 JobWrapper 链，等价于构造后调用 UseWrappers
+func WithJobWrappers(wrappers ...JobWrapper) SchedulerOption {
+	return func(ts *TaskScheduler) { ts.WrapperChain = wrappers }
+}
+
+// NewScheduler 用函数式选项构建 TaskScheduler，未显式传入的选项沿用过去硬编码的默认值，
+// 因此不传任何 opts 等价于原先的 initScheduler()
+func NewScheduler(opts ...SchedulerOption) *TaskScheduler {
+	ts := &TaskScheduler{
+		Parser:        NewParser(SecondOptional | Minute | Hour | Dom | Month | Dow | Descriptor),
+		planIndex:     make(map[string]*planHeapItem),
+		eventBuffer:   3000,
+		resultBuffer:  3000,
+		lockJitter:    time.Second,
+		retryAttempts: 5,
+		logger:        logrus.StandardLogger(),
+		metrics:       noopMetrics{},
+		clock:         realClock{},
+	}
+	for _, opt := range opts {
+		opt(ts)
+	}
+
+	ts.TaskEventChan = make(chan *common.TaskEvent, ts.eventBuffer)
+	ts.TaskExecuteResultChan = make(chan *common.TaskExecuteResult, ts.resultBuffer)
+	return ts
+}
+
+// retryWithBackoff 重试 f 最多 attempts 次，每次失败后按 backoff(attempt) 返回的时长等待，
+// backoff 为 nil 时不等待直接重试
+func retryWithBackoff(attempts int, backoff func(attempt int) time.Duration, f func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if backoff != nil {
+			if d := backoff(i); d > 0 {
+				time.Sleep(d)
+			}
+		}
+	}
+	return err
+}
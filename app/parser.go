@@ -0,0 +1,423 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 描述一种调度策略，Next 返回严格晚于 t 的下一次触发时间
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// ParseOption 用位运算标记 Parser 接受的表达式字段，便于在标准 5 段式与秒级 6 段式之间切换
+type ParseOption int
+
+const (
+	Second         ParseOption = 1 << iota // 秒字段，默认不开启
+	SecondOptional                         // 秒字段可选（存在则按 6 段解析，否则按 5 段解析）
+	Minute                                 // 分钟字段，必选
+	Hour                                   // 小时字段，必选
+	Dom                                    // 日字段，必选
+	Month                                  // 月字段，必选
+	Dow                                    // 星期字段，必选
+	DowOptional                            // 星期字段可选
+	Descriptor                             // 允许 @yearly、@every 5m 等描述符
+
+	standardParseOption = Minute | Hour | Dom | Month | Dow | Descriptor
+)
+
+// Parser 按照构造时给定的 ParseOption 解析 crontab 表达式
+type Parser struct {
+	options ParseOption
+}
+
+// NewParser 根据 options 构建一个可复用的表达式解析器，Second/Dow 最多只能有一个是 Optional
+func NewParser(options ParseOption) Parser {
+	optionals := 0
+	if options&DowOptional > 0 {
+		options |= Dow
+		optionals++
+	}
+	if options&SecondOptional > 0 {
+		options |= Second
+		optionals++
+	}
+	if optionals > 1 {
+		panic("app: multiple optionals may not be configured")
+	}
+	return Parser{options}
+}
+
+// StandardParser 等价于 crontab(5) 的标准 5 段式，外加 @hourly 一类的描述符
+var StandardParser = NewParser(standardParseOption)
+
+// WithSecondsParser 在标准字段前额外接受一个秒字段（6 段式）
+var WithSecondsParser = NewParser(Second | Minute | Hour | Dom | Month | Dow | Descriptor)
+
+// Parse 将 spec 解析为 Schedule，支持可选的 "CRON_TZ=Asia/Shanghai " 前缀
+func (p Parser) Parse(spec string) (Schedule, error) {
+	if len(spec) == 0 {
+		return nil, fmt.Errorf("app: empty spec string")
+	}
+
+	loc := time.Local
+	if strings.HasPrefix(spec, "CRON_TZ=") {
+		i := strings.Index(spec, " ")
+		if i == -1 {
+			return nil, fmt.Errorf("app: missing spec after CRON_TZ=")
+		}
+		eq := strings.Index(spec, "=")
+		var err error
+		if loc, err = time.LoadLocation(spec[eq+1 : i]); err != nil {
+			return nil, fmt.Errorf("app: bad location %s: %v", spec[eq+1:i], err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		if p.options&Descriptor == 0 {
+			return nil, fmt.Errorf("app: parser does not accept descriptors: %v", spec)
+		}
+		return parseDescriptor(spec, loc)
+	}
+
+	fields := strings.Fields(spec)
+
+	fields, err := normalizeFields(fields, p.options)
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := getField(fields[0], seconds)
+	if err != nil {
+		return nil, err
+	}
+	minute, err := getField(fields[1], minutes)
+	if err != nil {
+		return nil, err
+	}
+	hour, err := getField(fields[2], hours)
+	if err != nil {
+		return nil, err
+	}
+	dayOfMonth, err := getField(fields[3], dom)
+	if err != nil {
+		return nil, err
+	}
+	month, err := getField(fields[4], months)
+	if err != nil {
+		return nil, err
+	}
+	dayOfWeek, err := getField(fields[5], dow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpecSchedule{
+		Second:   second,
+		Minute:   minute,
+		Hour:     hour,
+		Dom:      dayOfMonth,
+		Month:    month,
+		Dow:      dayOfWeek,
+		Location: loc,
+	}, nil
+}
+
+// normalizeFields 补全可选字段，并校验字段数量是否匹配 options
+func normalizeFields(fields []string, options ParseOption) ([]string, error) {
+	if options&DowOptional > 0 && options&SecondOptional > 0 {
+		return nil, fmt.Errorf("app: cannot have both SecondOptional and DowOptional")
+	}
+
+	options |= Minute | Hour | Dom | Month | Dow
+	expectedFields := 0
+	for _, optional := range []ParseOption{Second, Dow} {
+		if options&optional > 0 {
+			expectedFields++
+		}
+	}
+	expectedFields += 4 // minute hour dom month 必选
+
+	max := expectedFields
+	min := max
+	if options&SecondOptional > 0 {
+		min--
+	} else if options&DowOptional > 0 {
+		min--
+	}
+
+	if count := len(fields); count < min || count > max {
+		if min == max {
+			return nil, fmt.Errorf("app: expected exactly %d fields, found %d: %s", min, count, strings.Join(fields, " "))
+		}
+		return nil, fmt.Errorf("app: expected %d to %d fields, found %d: %s", min, max, count, strings.Join(fields, " "))
+	}
+
+	// Parse 总是按「秒 分 时 日 月 周」6 段读取，缺省的秒字段补 "0"，缺省的星期字段补 "*"
+	populated := make([]string, 0, 6)
+	if options&Second == 0 || (options&SecondOptional > 0 && len(fields) == min) {
+		populated = append(populated, "0")
+	}
+	populated = append(populated, fields...)
+	if options&DowOptional > 0 && len(fields) == min {
+		populated = append(populated, "*")
+	}
+	return populated, nil
+}
+
+func parseDescriptor(descriptor string, loc *time.Location) (Schedule, error) {
+	switch {
+	case strings.HasPrefix(descriptor, "@every "):
+		duration, err := time.ParseDuration(descriptor[len("@every "):])
+		if err != nil {
+			return nil, fmt.Errorf("app: failed to parse duration %s: %s", descriptor, err)
+		}
+		// 不足 1s 的间隔按 1s 处理，保证 Next 严格晚于 t
+		if duration < time.Second {
+			duration = time.Second
+		}
+		return ConstantDelaySchedule{Delay: duration}, nil
+	}
+
+	var spec string
+	switch descriptor {
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@hourly":
+		spec = "0 * * * *"
+	default:
+		return nil, fmt.Errorf("app: unrecognized descriptor: %s", descriptor)
+	}
+
+	schedule, err := NewParser(standardParseOption).Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	s := schedule.(*SpecSchedule)
+	s.Location = loc
+	return s, nil
+}
+
+// bounds 描述某个字段的合法取值范围以及 jan/mon 之类的命名别名
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	dom     = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dow = bounds{0, 6, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+const starBit = 1 << 63
+
+// getField 解析逗号分隔的单个字段（如 "1,15,*/5"）并把各段取值 OR 成一个位图
+func getField(field string, r bounds) (uint64, error) {
+	var bits uint64
+	ranges := strings.FieldsFunc(field, func(r rune) bool { return r == ',' })
+	for _, expr := range ranges {
+		bit, err := getRange(expr, r)
+		if err != nil {
+			return bits, err
+		}
+		bits |= bit
+	}
+	return bits, nil
+}
+
+// getRange 解析单个区间表达式，支持 "*"、"n"、"n-m"、"n/s"、"n-m/s" 以及 jan/mon 类别名
+func getRange(expr string, r bounds) (uint64, error) {
+	var (
+		start, end, step uint
+		rangeAndStep     = strings.Split(expr, "/")
+		lowAndHigh       = strings.Split(rangeAndStep[0], "-")
+		singleDigit      = len(lowAndHigh) == 1
+		err              error
+	)
+
+	var extra uint64
+	if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+		start = r.min
+		end = r.max
+		extra = starBit
+	} else {
+		start, err = parseIntOrName(lowAndHigh[0], r.names)
+		if err != nil {
+			return 0, err
+		}
+		switch len(lowAndHigh) {
+		case 1:
+			end = start
+		case 2:
+			end, err = parseIntOrName(lowAndHigh[1], r.names)
+			if err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("app: too many hyphens: %s", expr)
+		}
+	}
+
+	switch len(rangeAndStep) {
+	case 1:
+		step = 1
+	case 2:
+		step, err = mustParseInt(rangeAndStep[1])
+		if err != nil {
+			return 0, err
+		}
+		if singleDigit {
+			end = r.max
+		}
+	default:
+		return 0, fmt.Errorf("app: too many slashes: %s", expr)
+	}
+
+	if start < r.min {
+		return 0, fmt.Errorf("app: beginning of range (%d) below minimum (%d): %s", start, r.min, expr)
+	}
+	if end > r.max {
+		return 0, fmt.Errorf("app: end of range (%d) above maximum (%d): %s", end, r.max, expr)
+	}
+	if start > end {
+		return 0, fmt.Errorf("app: beginning of range (%d) beyond end of range (%d): %s", start, end, expr)
+	}
+	if step == 0 {
+		return 0, fmt.Errorf("app: step of range should be a positive number: %s", expr)
+	}
+
+	return getBits(start, end, step) | extra, nil
+}
+
+func parseIntOrName(expr string, names map[string]uint) (uint, error) {
+	if names != nil {
+		if namedInt, ok := names[strings.ToLower(expr)]; ok {
+			return namedInt, nil
+		}
+	}
+	return mustParseInt(expr)
+}
+
+func mustParseInt(expr string) (uint, error) {
+	num, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("app: failed to parse int from %s: %s", expr, err)
+	}
+	if num < 0 {
+		return 0, fmt.Errorf("app: negative number (%d) not allowed: %s", num, expr)
+	}
+	return uint(num), nil
+}
+
+func getBits(min, max, step uint) uint64 {
+	var bits uint64
+	if step == 1 {
+		return ^(math.MaxUint64 << (max + 1)) & (math.MaxUint64 << min)
+	}
+	for i := min; i <= max; i += step {
+		bits |= 1 << i
+	}
+	return bits
+}
+
+func all(r bounds) uint64 {
+	return getBits(r.min, r.max, 1) | starBit
+}
+
+// SpecSchedule 是标准 crontab 表达式解析后的内部表示，六个字段各自是一个取值位图
+type SpecSchedule struct {
+	Second, Minute, Hour, Dom, Month, Dow uint64
+	Location                              *time.Location
+}
+
+// Next 返回严格晚于 t 的下一次触发时间，找不到（理论上 5 年内必有解）时返回零值
+func (s *SpecSchedule) Next(t time.Time) time.Time {
+	if s.Location != time.Local {
+		t = t.In(s.Location)
+	}
+
+	t = t.Add(1*time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&s.Month == 0 {
+		if t.Month() == time.December {
+			t = time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, s.Location)
+		} else {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+		}
+		if t.Year() > yearLimit {
+			return time.Time{}
+		}
+	}
+	for !dayMatches(s, t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Hour())&s.Hour == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.Location)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Minute())&s.Minute == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.Location)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Second())&s.Second == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second()+1, 0, s.Location)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t.In(s.Location)
+}
+
+func dayMatches(s *SpecSchedule, t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.Dom > 0
+	dowMatch := 1<<uint(t.Weekday())&s.Dow > 0
+	if s.Dom&starBit > 0 || s.Dow&starBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// ConstantDelaySchedule 实现 "@every 5m" 这类固定间隔的调度，不对齐到整秒之外的边界
+type ConstantDelaySchedule struct {
+	Delay time.Duration
+}
+
+// Next 简单地在 t 基础上加上固定间隔
+func (s ConstantDelaySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Delay - time.Duration(t.Nanosecond())*time.Nanosecond)
+}
@@ -1,13 +1,14 @@
 package app
 
 import (
+	"container/heap"
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/holdno/gopherCron/common"
-	"github.com/holdno/gopherCron/utils"
 
 	"github.com/sirupsen/logrus"
 )
@@ -15,18 +16,50 @@ import (
 // Scheduler 任务调度
 type TaskScheduler struct {
 	TaskEventChan         chan *common.TaskEvent // 任务事件队列
-	PlanTable             sync.Map
 	TaskExecuteResultChan chan *common.TaskExecuteResult
-	// PlanTable             map[string]*common.TaskSchedulePlan  // 任务调度计划表
-	TaskExecutingTable sync.Map // 任务执行中的记录表
+	TaskExecutingTable    sync.Map // 任务执行中的记录表
+
+	// planMu 保护 planHeap 和 planIndex 的并发访问
+	planMu    sync.Mutex
+	planHeap  planHeap                 // 按 NextTime 排序的最小堆，堆顶是最近一次需要调度的任务
+	planIndex map[string]*planHeapItem // schedulerKey -> 堆节点，用于 O(log N) 的更新/删除
+
+	// Parser 用于解析任务的 cron 表达式，默认支持标准 5 段式、@every/@daily 等描述符以及 CRON_TZ 前缀
+	Parser Parser
+	// WrapperChain 包裹每一次 TryStartTask 实际执行体的中间件链，按顺序叠加
+	WrapperChain []JobWrapper
+	// OnceTable 等待执行的一次性/延迟任务表，与 planHeap 并列参与 TrySchedule
+	OnceTable sync.Map
+
+	// concurrencyTable 记录每个 schedulerKey 的 ConcurrencyPolicy，未设置时视为 ConcurrencySkip
+	concurrencyTable sync.Map
+	// queueTable 是 ConcurrencyQueue 策略下每个 schedulerKey 的有界等待队列
+	queueTable sync.Map
+
+	// closed 在 Stop 被调用后置 1，之后 PushEvent 不再接受新事件
+	closed int32
+	// wg 跟踪 TryStartTask/TryStartOnceTask 启动的所有 goroutine，Stop 据此等待收尾
+	wg sync.WaitGroup
+
+	// 以下字段均可以通过 SchedulerOption 在 NewScheduler 时覆盖默认值，见 options.go
+	eventBuffer   int
+	resultBuffer  int
+	lockJitter    time.Duration
+	retryAttempts int
+	retryBackoff  func(attempt int) time.Duration
+	logger        logrus.FieldLogger
+	metrics       MetricsReporter
+	clock         Clock
 }
 
+// initScheduler 是 NewScheduler() 的历史别名，保留以兼容未迁移到函数式选项的调用方
 func initScheduler() *TaskScheduler {
-	scheduler := &TaskScheduler{
-		TaskEventChan:         make(chan *common.TaskEvent, 3000),
-		TaskExecuteResultChan: make(chan *common.TaskExecuteResult, 3000),
-	}
-	return scheduler
+	return NewScheduler()
+}
+
+// UseWrappers 替换调度器默认的 JobWrapper 链，每次任务触发都会套用这条链
+func (ts *TaskScheduler) UseWrappers(wrappers ...JobWrapper) {
+	ts.WrapperChain = wrappers
 }
 
 func (ts *TaskScheduler) SetExecutingTask(key string, task *common.TaskExecutingInfo) {
@@ -51,42 +84,89 @@ func (ts *TaskScheduler) PushTaskResult(result *common.TaskExecuteResult) {
 }
 
 func (a *client) GetPlan(key string) (*common.TaskSchedulePlan, bool) {
-	var (
-		value interface{}
-		ok    bool
-	)
-	if value, ok = a.scheduler.PlanTable.Load(key); ok {
-		return value.(*common.TaskSchedulePlan), true
-	}
+	a.scheduler.planMu.Lock()
+	defer a.scheduler.planMu.Unlock()
 
-	return nil, false
+	item, ok := a.scheduler.planIndex[key]
+	if !ok {
+		return nil, false
+	}
+	return item.plan, true
 }
 
+// SetPlan 登记或更新一个调度计划。已存在则就地更新 NextTime 并 heap.Fix，否则作为新节点入堆，
+// 两种情况都是 O(log N)，不再需要整表扫描
 func (ts *TaskScheduler) SetPlan(key string, value *common.TaskSchedulePlan) {
-	ts.PlanTable.Store(key, value)
+	ts.planMu.Lock()
+	defer ts.planMu.Unlock()
+
+	if item, ok := ts.planIndex[key]; ok {
+		item.plan = value
+		heap.Fix(&ts.planHeap, item.index)
+		return
+	}
+
+	item := &planHeapItem{key: key, plan: value}
+	heap.Push(&ts.planHeap, item)
+	ts.planIndex[key] = item
 }
 
+// PlanRange 遍历所有调度计划，遍历顺序不保证与堆序一致，仅用于不关心顺序的场景
 func (ts *TaskScheduler) PlanRange(f func(key string, value *common.TaskSchedulePlan) bool) {
-	ts.PlanTable.Range(func(key, value interface{}) bool {
-		f(key.(string), value.(*common.TaskSchedulePlan))
-		return true
-	})
+	ts.planMu.Lock()
+	items := make([]*planHeapItem, len(ts.planHeap))
+	copy(items, ts.planHeap)
+	ts.planMu.Unlock()
+
+	for _, item := range items {
+		if !f(item.key, item.plan) {
+			return
+		}
+	}
 }
 
+// PlanCount 返回当前调度计划数量，O(1)
 func (ts *TaskScheduler) PlanCount() int {
-	var count int
-	ts.PlanTable.Range(func(key, value interface{}) bool {
-		count++
-		return true
-	})
-	return count
+	ts.planMu.Lock()
+	defer ts.planMu.Unlock()
+	return len(ts.planHeap)
+}
+
+// PeekPlan 返回堆顶（NextTime 最早）的调度计划，堆为空时返回 false
+func (ts *TaskScheduler) PeekPlan() (*common.TaskSchedulePlan, bool) {
+	ts.planMu.Lock()
+	defer ts.planMu.Unlock()
+	if len(ts.planHeap) == 0 {
+		return nil, false
+	}
+	return ts.planHeap[0].plan, true
+}
+
+// FixPlan 在外部就地修改了某个 plan.NextTime 之后，调用这个方法让堆重新排序，O(log N)
+func (ts *TaskScheduler) FixPlan(key string) {
+	ts.planMu.Lock()
+	defer ts.planMu.Unlock()
+	if item, ok := ts.planIndex[key]; ok {
+		heap.Fix(&ts.planHeap, item.index)
+	}
 }
 
 func (a *client) RemovePlan(schedulerKey string) {
-	a.scheduler.PlanTable.Delete(schedulerKey)
+	ts := a.scheduler
+	ts.planMu.Lock()
+	defer ts.planMu.Unlock()
+
+	item, ok := ts.planIndex[schedulerKey]
+	if !ok {
+		return
+	}
+	heap.Remove(&ts.planHeap, item.index)
+	delete(ts.planIndex, schedulerKey)
 }
 
-func (a *client) Loop() {
+// Loop 是调度主循环，ctx 被取消时停止接收新的事件与调度周期并返回；
+// 收尾（等待在执行中的任务、flush 结果、释放锁）由 Stop 负责，Loop 本身只负责退出
+func (a *client) Loop(ctx context.Context) {
 	var (
 		taskEvent     *common.TaskEvent
 		scheduleAfter time.Duration
@@ -101,6 +181,9 @@ func (a *client) Loop() {
 
 	for {
 		select {
+		case <-ctx.Done():
+			scheduleTimer.Stop()
+			return
 		case taskEvent = <-a.scheduler.TaskEventChan:
 			// 对内存中的任务进行增删改查
 			a.handleTaskEvent(taskEvent)
@@ -127,18 +210,20 @@ func (a *client) handleTaskEvent(event *common.TaskEvent) {
 	// 临时调度
 	case common.TASK_EVENT_TEMPORARY:
 		// 构建执行计划
-		if taskSchedulePlan, err = common.BuildTaskSchedulerPlan(event.Task); err != nil {
+		if taskSchedulePlan, err = a.buildSchedulePlan(event.Task); err != nil {
 			logrus.WithField("Error", err.Error()).Error("build task schedule plan error")
 			return
 		}
 		a.TryStartTask(taskSchedulePlan)
 	case common.TASK_EVENT_SAVE:
 		// 构建执行计划
-		if taskSchedulePlan, err = common.BuildTaskSchedulerPlan(event.Task); err != nil {
+		if taskSchedulePlan, err = a.buildSchedulePlan(event.Task); err != nil {
 			logrus.WithField("Error", err.Error()).Error("build task schedule plan error")
 			return
 		}
 		if event.Task.Status == 1 {
+			// ConcurrencyPolicy 随任务保存事件一起下发，支持运行时修改而无需重启 worker
+			a.scheduler.SetConcurrencyPolicy(event.Task.SchedulerKey(), ConcurrencyPolicy(event.Task.ConcurrencyPolicy))
 			a.scheduler.SetPlan(event.Task.SchedulerKey(), taskSchedulePlan)
 			return
 		}
@@ -151,75 +236,122 @@ func (a *client) handleTaskEvent(event *common.TaskEvent) {
 		if taskExecuteinfo, taskExecuting = a.scheduler.CheckTaskExecuting(event.Task.SchedulerKey()); taskExecuting {
 			taskExecuteinfo.CancelFunc()
 		}
+	case TASK_EVENT_ONCE:
+		a.handleOnceTaskEvent(event)
 	}
 }
 
-// 重新计算任务调度状态
-func (a *client) TrySchedule() time.Duration {
-	var (
-		now      time.Time
-		nearTime *time.Time
-	)
-
-	// 如果当前任务调度表中没有任务的话 可以随机睡眠后再尝试
-	if a.scheduler.PlanCount() == 0 {
-		return time.Second
+// buildSchedulePlan 把 task.Cron 交给 scheduler.Parser 解析（支持标准 5 段式、带秒的 6 段式、
+// @every/@daily 等描述符以及 CRON_TZ 前缀），取代过去固定调用 common.BuildTaskSchedulerPlan
+// 只能识别一种 cron 格式的做法
+func (a *client) buildSchedulePlan(task *common.Task) (*common.TaskSchedulePlan, error) {
+	schedule, err := a.scheduler.Parser.Parse(task.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("parse cron expression %q: %w", task.Cron, err)
 	}
 
-	now = time.Now()
-	// 遍历所有任务
-	a.scheduler.PlanRange(func(schedulerKey string, plan *common.TaskSchedulePlan) bool {
-		// 如果调度时间是在现在或之前再或者为临时调度任务
-		if plan.NextTime.Before(now) || plan.NextTime.Equal(now) {
-			// 尝试执行任务
-			// 因为可能上一次任务还没执行结束
-			a.TryStartTask(plan)
-			plan.NextTime = plan.Expr.Next(now) // 更新下一次执行时间
+	now := time.Now()
+	return &common.TaskSchedulePlan{
+		Task:     task,
+		Expr:     schedule,
+		NextTime: schedule.Next(now),
+	}, nil
+}
+
+// TrySchedule 重新计算任务调度状态
+// 常规任务存放在 planHeap 最小堆中，堆顶即是下一个最早需要触发的任务，命中后 pop 出来、
+// 重新计算 NextTime 再塞回堆里，不再需要像过去一样每次唤醒都遍历整个 PlanTable
+func (a *client) TrySchedule() time.Duration {
+	now := time.Now()
+	var nearTime *time.Time
+
+	ts := a.scheduler
+	// fired 记录本轮已经触发过的 key，保证每个任务在一次 TrySchedule 调用里最多触发一次
+	// （与重构前 PlanRange 的行为一致），避免 Expr.Next 返回一个仍然 <= now 的时间
+	// （比如无法满足的表达式返回零值）时堆顶永远到期，TryStartTask 被无限重入
+	fired := make(map[string]bool)
+	for {
+		ts.planMu.Lock()
+		if len(ts.planHeap) == 0 {
+			ts.planMu.Unlock()
+			break
+		}
+		top := ts.planHeap[0]
+		if top.plan.NextTime.After(now) {
+			t := top.plan.NextTime
+			nearTime = &t
+			ts.planMu.Unlock()
+			break
+		}
+		if fired[top.key] {
+			// 本轮已经处理过这个 key，但它仍然到期，留到下一次 wake-up 再检查，
+			// 防止表达式异常（见下方 IsZero 分支）导致忙等
+			t := now.Add(time.Second)
+			nearTime = &t
+			ts.planMu.Unlock()
+			break
+		}
+		fired[top.key] = true
+		ts.planMu.Unlock()
+
+		// 堆顶已经到期，尝试执行后更新 NextTime 并重新入堆（位置由 heap.Fix 调整）
+		a.TryStartTask(top.plan)
+		next := top.plan.Expr.Next(now)
+		if next.IsZero() || !next.After(now) {
+			// 表达式无法得到严格晚于 now 的下一次时间（例如不可满足的 cron 表达式），
+			// 这里不能原样写回，否则这个 key 会永远占据堆顶；先顺延一分钟，留给排查
+			a.logger.Errorf("task: %s, id: %s, schedule produced a non-advancing next time (%v), deferred by 1m",
+				top.plan.Task.Name, top.plan.Task.TaskID, next)
+			next = now.Add(time.Minute)
 		}
+		top.plan.NextTime = next
+		ts.FixPlan(top.key)
+	}
 
-		// 获取下一个要执行任务的时间
-		if nearTime == nil || plan.NextTime.Before(*nearTime) {
-			nearTime = &plan.NextTime
+	// 一次性任务数量通常远小于常规任务，继续用线性扫描即可
+	a.scheduler.OnceRange(func(key string, entry *OnceTaskEntry) bool {
+		if entry.RunAt.Before(now) || entry.RunAt.Equal(now) {
+			a.TryStartOnceTask(entry)
+			return true
 		}
 
+		if nearTime == nil || entry.RunAt.Before(*nearTime) {
+			nearTime = &entry.RunAt
+		}
 		return true
 	})
 
+	if nearTime == nil {
+		// 两张表都没有待执行任务，随机睡眠后再尝试
+		return time.Second
+	}
+
 	// 下次调度时间 (最近要执行的任务调度时间 - 当前时间)
-	return (*nearTime).Sub(now)
+	return nearTime.Sub(now)
 }
 
 // TryStartTask 开始执行任务
+// 是否跳过/延迟/排队/替换由任务的 ConcurrencyPolicy 决定，Recover 始终生效，
+// scheduler.WrapperChain 叠加在它们外层
 func (a *client) TryStartTask(plan *common.TaskSchedulePlan) {
-	// 执行的任务可能会执行很久
-	// 需要防止并发
-	var (
-		taskExecuteInfo *common.TaskExecutingInfo
-		taskExecuting   bool
-		err             error
-	)
-
-	if taskExecuteInfo, taskExecuting = a.scheduler.CheckTaskExecuting(plan.Task.SchedulerKey()); taskExecuting {
-		a.scheduler.PushTaskResult(&common.TaskExecuteResult{
-			ExecuteInfo: common.BuildTaskExecuteInfo(plan),
-			Output:      "last task was not completed",
-			Err:         fmt.Sprintf("task %s execute error: last task was not completed", plan.Task.Name),
-			StartTime:   time.Now(),
-			EndTime:     time.Now(),
-		})
-		return
-	}
-
 	plan.Task.ClientIP = a.localip
 
-	go func() {
+	key := plan.Task.SchedulerKey()
+
+	var taskExecuteInfo *common.TaskExecutingInfo
+	job := func() {
 		// 构建执行状态信息
 		taskExecuteInfo = common.BuildTaskExecuteInfo(plan)
+		_, cancel := context.WithCancel(context.Background())
+		// CancelFunc 是 ReplaceIfStillRunning/Kill/Timeout 中断一次正在执行的任务的唯一入口，
+		// 不能留空；真正的取消信号（中断 ExecuteTask 里的命令执行）由 a.ExecuteTask 内部消费
+		taskExecuteInfo.CancelFunc = cancel
+		defer cancel()
 		if plan.Task.Noseize == 0 {
 			lk := a.etcd.Lock(plan.Task)
 			// 保存执行状态
 			// 避免分布式集群上锁偏斜 (每台机器的时钟可能不是特别的准确 导致某一台机器总能抢到锁)
-			time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
+			time.Sleep(time.Duration(rand.Int63n(int64(a.scheduler.lockJitter) + 1)))
 			if err := lk.TryLock(); err != nil {
 				a.logger.Warnf("task: %s, id: %s, lock error, %v", plan.Task.Name,
 					plan.Task.TaskID, err)
@@ -228,12 +360,13 @@ func (a *client) TryStartTask(plan *common.TaskSchedulePlan) {
 			defer lk.Unlock()
 		}
 
-		a.scheduler.SetExecutingTask(plan.Task.SchedulerKey(), taskExecuteInfo)
-		if err = a.SetTaskRunning(*plan.Task); err != nil {
+		a.scheduler.SetExecutingTask(key, taskExecuteInfo)
+		if err := a.SetTaskRunning(*plan.Task); err != nil {
 			a.logger.Warnf("task: %s, id: %s, change running status error, %v", plan.Task.Name,
 				plan.Task.TaskID, err)
+			a.scheduler.DeleteExecutingTask(key)
 			// retry
-			if err = utils.RetryFunc(5, func() error {
+			if err = retryWithBackoff(a.scheduler.retryAttempts, a.scheduler.retryBackoff, func() error {
 				return a.TemporarySchedulerTask(plan.Task)
 			}); err != nil {
 				a.logger.Errorf(
@@ -244,7 +377,7 @@ func (a *client) TryStartTask(plan *common.TaskSchedulePlan) {
 		}
 
 		result := a.ExecuteTask(taskExecuteInfo)
-		if err = utils.RetryFunc(5, func() error {
+		if err := retryWithBackoff(a.scheduler.retryAttempts, a.scheduler.retryBackoff, func() error {
 			return a.SetTaskNotRunning(*plan.Task)
 		}); err != nil {
 			a.logger.Errorf("task: %s, id: %s, failed to change running status, the task is finished, error: %v",
@@ -255,6 +388,47 @@ func (a *client) TryStartTask(plan *common.TaskSchedulePlan) {
 		a.scheduler.DeleteExecutingTask(result.ExecuteInfo.Task.SchedulerKey())
 		// 执行结束后 返回给scheduler
 		a.scheduler.PushTaskResult(result)
+	}
+
+	// base 是并发策略 + panic 恢复，无论 WrapperChain 是否被用户自定义（WithJobWrappers/
+	// UseWrappers）都必须生效，否则一旦配置了自定义链就会悄悄丢失 Skip/Replace/Queue 的
+	// 去重语义和 Recover 的保护。所以这里总是把 base 接在用户链最内侧，而不是靠 nil 判断二选一
+	base := []JobWrapper{Recover(a.logger)}
+	switch a.scheduler.GetConcurrencyPolicy(key) {
+	case ConcurrencyAllow:
+		// 不做去重，允许与上一次调度并行执行
+	case ConcurrencyReplace:
+		base = append(base, ReplaceIfStillRunning(a.scheduler, key))
+	case ConcurrencyQueue:
+		base = append(base, QueueIfStillRunning(a.scheduler, key, plan, a.TryStartTask))
+	case ConcurrencyDelay:
+		base = append(base, DelayIfStillRunning(a.scheduler, key, a.logger))
+	default: // ConcurrencySkip，也是未配置过策略时的默认行为
+		base = append(base, SkipIfStillRunning(a.scheduler, key, func() {
+			a.scheduler.metrics.Incr("task_skipped_still_running", map[string]string{
+				"task_name": plan.Task.Name,
+			})
+		}))
+	}
+
+	// Timeout 要直接包裹原始 job（而不是去重策略判断本身），否则 Skip/Delay/Queue 的等待
+	// 时间会被一起计入超时窗口
+	if plan.Timeout > 0 {
+		base = append(base, Timeout(plan.Timeout, func() {
+			if taskExecuteInfo != nil {
+				taskExecuteInfo.CancelFunc()
+			}
+		}))
+	}
+
+	wrappers := make([]JobWrapper, 0, len(a.scheduler.WrapperChain)+len(base))
+	wrappers = append(wrappers, a.scheduler.WrapperChain...)
+	wrappers = append(wrappers, base...)
+
+	a.scheduler.wg.Add(1)
+	go func() {
+		defer a.scheduler.wg.Done()
+		Chain(job, wrappers...)()
 	}()
 }
 
@@ -274,7 +448,10 @@ func (a *client) handleTaskResult(result *common.TaskExecuteResult) {
 	}
 }
 
-// 接收任务事件
+// PushEvent 接收任务事件，Stop 调用之后（isClosed）会静默丢弃新事件
 func (ts *TaskScheduler) PushEvent(event *common.TaskEvent) {
+	if ts.isClosed() {
+		return
+	}
 	ts.TaskEventChan <- event
 }
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"container/heap"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// buildPlans 构造 n 个 NextTime 各不相同的调度计划，用于对比扫描与堆两种实现的调度开销
+func buildPlans(n int) []*planHeapItem {
+	now := time.Now()
+	items := make([]*planHeapItem, n)
+	for i := 0; i < n; i++ {
+		items[i] = &planHeapItem{
+			key: time.Duration(i).String(),
+			plan: &common.TaskSchedulePlan{
+				NextTime: now.Add(time.Duration(i) * time.Second),
+			},
+		}
+	}
+	return items
+}
+
+// scanForNearest 模拟重构前 TrySchedule 的做法：每次都遍历整张表找出最近的 NextTime，O(N)
+func scanForNearest(table *sync.Map) time.Time {
+	var nearest time.Time
+	table.Range(func(_, value interface{}) bool {
+		plan := value.(*common.TaskSchedulePlan)
+		if nearest.IsZero() || plan.NextTime.Before(nearest) {
+			nearest = plan.NextTime
+		}
+		return true
+	})
+	return nearest
+}
+
+func benchmarkScan(b *testing.B, n int) {
+	var table sync.Map
+	for _, item := range buildPlans(n) {
+		table.Store(item.key, item.plan)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanForNearest(&table)
+	}
+}
+
+// benchmarkHeap 复现 TrySchedule 每次触发堆顶任务时真正做的事：取出堆顶、把它的 NextTime
+// 顺延 n 秒（排到其余所有计划之后）、再用 heap.Fix 把它调整回正确的位置，而不是只读一下堆顶（那样量不出堆顶到期后
+// 重新排序的代价，也是这个 benchmark 存在的意义——和 scanForNearest 的整表扫描做对比）
+func benchmarkHeap(b *testing.B, n int) {
+	h := make(planHeap, 0, n)
+	for _, item := range buildPlans(n) {
+		heap.Push(&h, item)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		top := h[0]
+		top.plan.NextTime = top.plan.NextTime.Add(time.Duration(n) * time.Second)
+		heap.Fix(&h, top.index)
+	}
+}
+
+func BenchmarkScan100(b *testing.B)   { benchmarkScan(b, 100) }
+func BenchmarkScan1000(b *testing.B)  { benchmarkScan(b, 1000) }
+func BenchmarkScan10000(b *testing.B) { benchmarkScan(b, 10000) }
+
+func BenchmarkHeap100(b *testing.B)   { benchmarkHeap(b, 100) }
+func BenchmarkHeap1000(b *testing.B)  { benchmarkHeap(b, 1000) }
+func BenchmarkHeap10000(b *testing.B) { benchmarkHeap(b, 10000) }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/holdno/gopherCron/app"
+)
+
+var drainDeadline = flag.Duration("drain-deadline", 30*time.Second,
+	"优雅关闭时等待执行中任务收尾的最长时长，超过后会强制 cancel")
+
+// worker 是调度节点的标准启动入口：构造 client 后阻塞在 RunUntilSignal 上，收到
+// SIGINT/SIGTERM 时按 Start/Stop 定义的顺序完成优雅关闭再退出，而不是直接 kill -9。
+func main() {
+	flag.Parse()
+
+	client, err := app.NewClient()
+	if err != nil {
+		log.Fatalf("init client error: %v", err)
+	}
+
+	if err := client.RunUntilSignal(*drainDeadline); err != nil {
+		log.Fatalf("worker exit with error: %v", err)
+	}
+}
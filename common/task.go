@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// TaskEvent 的事件类型，调度协程的 TaskEventChan 里流转的就是这些类型之一
+const (
+	TASK_EVENT_SAVE = iota + 1
+	TASK_EVENT_DELETE
+	TASK_EVENT_KILL
+	TASK_EVENT_TEMPORARY
+)
+
+// Schedule 描述一种调度策略，Next 返回严格晚于 t 的下一次触发时间；
+// app.Parser 解析出来的 Schedule 实现（SpecSchedule/ConstantDelaySchedule）按方法集满足这个接口
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Task 描述一条任务的静态配置
+type Task struct {
+	TaskID    string
+	ProjectID int64
+	Name      string
+	Command   string
+	Cron      string
+	Status    int // 1 表示启用，其余值在 TASK_EVENT_SAVE 时会被当作删除处理
+	Noseize   int // 非 0 表示这个任务不需要分布式锁（例如单机任务）
+	ClientIP  string
+
+	// ConcurrencyPolicy 对应 app.ConcurrencyPolicy 的取值，决定上一次调度还未结束时
+	// 新一次触发该怎么处理（Skip/Allow/Queue/Replace/Delay），随 TASK_EVENT_SAVE 下发
+	ConcurrencyPolicy int
+}
+
+// SchedulerKey 是任务在调度计划表、执行中记录表、分布式锁上使用的唯一标识
+func (t *Task) SchedulerKey() string {
+	return fmt.Sprintf("%d_%s", t.ProjectID, t.TaskID)
+}
+
+// TaskEvent 是调度协程从 TaskEventChan 里接收到的一条事件
+type TaskEvent struct {
+	EventType int
+	Task      *Task
+	// RunAt 只在一次性/延迟任务事件（app.TASK_EVENT_ONCE）中使用，表示任务应当被
+	// 触发的绝对时间；常规事件不设置这个字段
+	RunAt time.Time
+}
+
+// TaskSchedulePlan 是任务的 cron 表达式解析之后的调度计划
+type TaskSchedulePlan struct {
+	Task     *Task
+	Expr     Schedule
+	NextTime time.Time
+
+	// Timeout 是本次调度单次执行允许占用的最长时间，0 表示不设上限。超时后
+	// app.Timeout 会调用 TaskExecutingInfo.CancelFunc 中断执行，而不是任其无限跑下去
+	Timeout time.Duration
+}
+
+// TaskExecutingInfo 描述一次正在执行中的任务
+type TaskExecutingInfo struct {
+	Task       *Task
+	PlanTime   time.Time
+	RealTime   time.Time
+	CancelFunc func()
+}
+
+// TaskExecuteResult 是一次任务执行结束后的结果，Err 非空时会触发 Warning 上报
+type TaskExecuteResult struct {
+	ExecuteInfo *TaskExecutingInfo
+	Output      string
+	Err         string
+	StartTime   time.Time
+	EndTime     time.Time
+}
+
+// BuildTaskExecuteInfo 根据调度计划构建一份执行状态信息，NextTime 作为本次执行的计划触发时间
+func BuildTaskExecuteInfo(plan *TaskSchedulePlan) *TaskExecutingInfo {
+	return &TaskExecutingInfo{
+		Task:     plan.Task,
+		PlanTime: plan.NextTime,
+		RealTime: time.Now(),
+	}
+}
@@ -0,0 +1,150 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/holdno/gopherCron/common"
+)
+
+// Locker 是任务执行前抢占的分布式锁，TryLock 非阻塞，失败返回 error 由调用方决定是否放弃本次调度
+type Locker interface {
+	TryLock() error
+	Unlock()
+}
+
+// Manager 是 app.client 持有的 etcd 客户端，封装分布式锁以及一次性任务的持久化存储
+type Manager interface {
+	Lock(task *common.Task) Locker
+
+	// SaveOnceTask 把一次性任务以及它的触发时间写入 key，供集群内其它节点 watch 到后重建调度
+	SaveOnceTask(key string, task *common.Task, runAt time.Time) error
+	// DeleteOnceTask 删除一次性任务的持久化记录，执行成功或超过 retention 窗口后调用
+	DeleteOnceTask(key string) error
+	// WatchOnceTasks 启动对 onceJobSaveDir 前缀的 watch，prefix 下的每一条记录都会回调一次
+	// onPut（worker 启动时先做一次全量 list 保证重启后已持久化但未执行的任务不会丢）；
+	// 记录被删除时回调 onDelete。调用方负责把收到的任务重新登记进 OnceTable
+	WatchOnceTasks(prefix string, onPut func(task *common.Task, runAt time.Time), onDelete func(key string)) error
+
+	// ReleaseAll 释放当前节点持有的所有分布式锁，Stop 优雅关闭时调用
+	ReleaseAll()
+}
+
+// memoryManager 是一个进程内实现，真正的生产实现基于真实的 etcd 客户端（clientv3），
+// 这里只保证接口形状和语义（lock 去重、once 任务可以被 watch 回调重建）可用于测试/本地运行
+type memoryManager struct {
+	mu    sync.Mutex
+	locks map[string]struct{}
+
+	onceMu   sync.Mutex
+	onceJobs map[string]onceRecord
+
+	watchMu  sync.Mutex
+	watchers []onceWatcher
+}
+
+type onceRecord struct {
+	task  *common.Task
+	runAt time.Time
+}
+
+type onceWatcher struct {
+	prefix   string
+	onPut    func(task *common.Task, runAt time.Time)
+	onDelete func(key string)
+}
+
+// NewMemoryManager 构造一个进程内的 Manager 实现
+func NewMemoryManager() Manager {
+	return &memoryManager{
+		locks:    make(map[string]struct{}),
+		onceJobs: make(map[string]onceRecord),
+	}
+}
+
+type memoryLocker struct {
+	m   *memoryManager
+	key string
+}
+
+func (l *memoryLocker) TryLock() error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	if _, held := l.m.locks[l.key]; held {
+		return fmt.Errorf("etcd: lock %s already held", l.key)
+	}
+	l.m.locks[l.key] = struct{}{}
+	return nil
+}
+
+func (l *memoryLocker) Unlock() {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	delete(l.m.locks, l.key)
+}
+
+func (m *memoryManager) Lock(task *common.Task) Locker {
+	return &memoryLocker{m: m, key: task.SchedulerKey()}
+}
+
+func (m *memoryManager) SaveOnceTask(key string, task *common.Task, runAt time.Time) error {
+	m.onceMu.Lock()
+	m.onceJobs[key] = onceRecord{task: task, runAt: runAt}
+	m.onceMu.Unlock()
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	for _, w := range m.watchers {
+		if w.onPut != nil && strings.HasPrefix(key, w.prefix) {
+			w.onPut(task, runAt)
+		}
+	}
+	return nil
+}
+
+func (m *memoryManager) DeleteOnceTask(key string) error {
+	m.onceMu.Lock()
+	delete(m.onceJobs, key)
+	m.onceMu.Unlock()
+
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	for _, w := range m.watchers {
+		if w.onDelete != nil && strings.HasPrefix(key, w.prefix) {
+			w.onDelete(key)
+		}
+	}
+	return nil
+}
+
+// WatchOnceTasks 先把当前已经持久化的记录全部回放给 onPut（对应真实 etcd watch 的
+// "启动时先 list 一次，再跟进增量 watch"），随后把回调登记为 watcher 以便后续变更也能收到
+func (m *memoryManager) WatchOnceTasks(prefix string, onPut func(task *common.Task, runAt time.Time), onDelete func(key string)) error {
+	m.onceMu.Lock()
+	existing := make([]onceRecord, 0, len(m.onceJobs))
+	for key, record := range m.onceJobs {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			existing = append(existing, record)
+		}
+	}
+	m.onceMu.Unlock()
+
+	for _, record := range existing {
+		if onPut != nil {
+			onPut(record.task, record.runAt)
+		}
+	}
+
+	m.watchMu.Lock()
+	m.watchers = append(m.watchers, onceWatcher{prefix: prefix, onPut: onPut, onDelete: onDelete})
+	m.watchMu.Unlock()
+	return nil
+}
+
+func (m *memoryManager) ReleaseAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locks = make(map[string]struct{})
+}